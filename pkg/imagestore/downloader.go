@@ -0,0 +1,261 @@
+package imagestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// casDirName is the top-level directory, relative to dataDir, that
+	// holds content-addressed artifacts so a single ISO shared by
+	// multiple version entries is only ever downloaded once.
+	casDirName = "by-hash/sha256"
+	partSuffix = ".part"
+	etagSuffix = ".etag"
+)
+
+// Downloader fetches iso_url/rootfs_url artifacts into a content-addressed
+// store, verifying a declared sha256 where one is known and resuming
+// partial downloads across restarts instead of re-fetching multi-gigabyte
+// files from scratch.
+type Downloader struct {
+	dataDir string
+	client  HTTPDoer
+
+	// group coalesces concurrent DownloadToFile calls for the same
+	// artifact (keyed by sha256, or by URL when no digest is declared)
+	// into a single fetch, since Populate fans out one goroutine per
+	// (version, arch) and multiple entries may share an iso_url/iso_sha256.
+	group singleflight.Group
+}
+
+func NewDownloader(dataDir string, client HTTPDoer) *Downloader {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Downloader{dataDir: dataDir, client: client}
+}
+
+// DownloadToFile ensures destPath exists and, when expectedSHA256 is
+// non-empty, contains data matching that digest. It stores the fetched
+// bytes under dataDir/by-hash/sha256/<digest> and makes destPath a symlink
+// to that location, so repeated version entries that reference the same
+// artifact share a single copy on disk.
+func (d *Downloader) DownloadToFile(ctx context.Context, url, destPath, expectedSHA256 string) error {
+	casPath, err := d.fetchOnce(ctx, url, expectedSHA256)
+	if err != nil {
+		return err
+	}
+	return linkDestination(casPath, destPath)
+}
+
+// VerifyChecksum ensures the content at url matches expectedSHA256,
+// downloading it into the content-addressed store (deduplicated against
+// any other artifact with the same digest) if it is not already present
+// and verified locally. Unlike DownloadToFile it does not create a
+// version-named destination symlink: it is for artifacts such as
+// rootfs_url that this package never serves itself, only references by
+// URL, but whose integrity operators still want checked before it's baked
+// into a minimal ISO template.
+func (d *Downloader) VerifyChecksum(ctx context.Context, url, expectedSHA256 string) error {
+	if expectedSHA256 == "" {
+		return nil
+	}
+	_, err := d.fetchOnce(ctx, url, expectedSHA256)
+	return err
+}
+
+// fetchOnce downloads url into the content-addressed store and returns its
+// final path, coalescing concurrent callers for the same URL/digest (via
+// d.group) into a single underlying fetch so a single ISO shared by
+// multiple version entries is only ever downloaded once even when those
+// entries are populated concurrently.
+func (d *Downloader) fetchOnce(ctx context.Context, url, expectedSHA256 string) (string, error) {
+	key := expectedSHA256
+	if key == "" {
+		key = url
+	}
+
+	v, err, _ := d.group.Do(key, func() (interface{}, error) {
+		if expectedSHA256 != "" {
+			casPath := d.casPath(expectedSHA256)
+			if verifyFileSHA256(casPath, expectedSHA256) == nil {
+				return casPath, nil
+			}
+		}
+
+		casPath, digest, err := d.fetch(ctx, url, expectedSHA256)
+		if err != nil {
+			return "", err
+		}
+
+		if expectedSHA256 != "" && digest != expectedSHA256 {
+			return "", fmt.Errorf("downloaded %s but sha256 %s did not match expected %s", url, digest, expectedSHA256)
+		}
+
+		return casPath, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return v.(string), nil
+}
+
+func (d *Downloader) casPath(digest string) string {
+	return filepath.Join(d.dataDir, casDirName, digest)
+}
+
+// fetch streams url to a ".part" file alongside the eventual content-
+// addressed destination, hashing as it writes, resuming from a prior
+// partial download when possible, and atomically renaming into place only
+// once the full body has been written successfully. It returns the final
+// content-addressed path and the sha256 digest of its contents.
+func (d *Downloader) fetch(ctx context.Context, url, expectedSHA256 string) (string, string, error) {
+	if err := os.MkdirAll(filepath.Join(d.dataDir, casDirName), 0755); err != nil {
+		return "", "", err
+	}
+
+	// Until we know the digest (it may only become known once the
+	// download completes, if expectedSHA256 was not supplied), stage the
+	// download under a name derived from the URL rather than the final
+	// hash.
+	partPath := filepath.Join(d.dataDir, casDirName, partFileName(url, expectedSHA256))
+	etagPath := partPath + etagSuffix
+
+	var resumeFrom int64
+	if fi, err := os.Stat(partPath); err == nil {
+		resumeFrom = fi.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	var priorETag string
+	if resumeFrom > 0 {
+		if b, err := ioutil.ReadFile(etagPath); err == nil {
+			priorETag = string(b)
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeFrom))
+			req.Header.Set("If-Range", priorETag)
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	hasher := sha256.New()
+	var f *os.File
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		log.Infof("resuming download of %s from byte %d", url, resumeFrom)
+		f, err = os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err := hashExistingPrefix(partPath, resumeFrom, hasher); err != nil {
+			f.Close()
+			return "", "", err
+		}
+	case http.StatusOK:
+		// Server ignored the Range request (or this is a fresh
+		// download); start over rather than risk corrupting the
+		// .part file with a full body appended to a stale prefix.
+		f, err = os.Create(partPath)
+		if err != nil {
+			return "", "", err
+		}
+	default:
+		return "", "", fmt.Errorf("request to %s returned error code %d", url, resp.StatusCode)
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		_ = ioutil.WriteFile(etagPath, []byte(etag), 0644)
+	}
+
+	if _, err := io.Copy(f, io.TeeReader(resp.Body, hasher)); err != nil {
+		f.Close()
+		return "", "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", "", err
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	casPath := d.casPath(digest)
+	if err := os.Rename(partPath, casPath); err != nil {
+		return "", "", err
+	}
+	os.Remove(etagPath)
+
+	return casPath, digest, nil
+}
+
+// hashExistingPrefix feeds the first n bytes of an already-downloaded
+// ".part" file into hasher, so resumed downloads produce the same digest
+// as a single uninterrupted download would have.
+func hashExistingPrefix(path string, n int64, hasher io.Writer) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(hasher, io.LimitReader(f, n))
+}
+
+func partFileName(url, expectedSHA256 string) string {
+	if expectedSHA256 != "" {
+		return expectedSHA256 + partSuffix
+	}
+	h := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(h[:]) + partSuffix
+}
+
+// verifyFileSHA256 returns nil only if path exists and its contents hash
+// to expectedSHA256, so a corrupt or truncated file is never mistaken for
+// a completed download.
+func verifyFileSHA256(path, expectedSHA256 string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if digest != expectedSHA256 {
+		return fmt.Errorf("file %s has sha256 %s, expected %s", path, digest, expectedSHA256)
+	}
+	return nil
+}
+
+// linkDestination makes destPath a symlink to casPath, replacing any
+// existing file or stale symlink at destPath.
+func linkDestination(casPath, destPath string) error {
+	if target, err := os.Readlink(destPath); err == nil && target == casPath {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	_ = os.Remove(destPath)
+	return os.Symlink(casPath, destPath)
+}
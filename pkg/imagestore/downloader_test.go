@@ -0,0 +1,107 @@
+package imagestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownloadToFileVerifiesChecksum(t *testing.T) {
+	const content = "rhcos-fixture-contents"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	dataDir := t.TempDir()
+	d := NewDownloader(dataDir, http.DefaultClient)
+
+	destPath := filepath.Join(dataDir, "rhcos-live.iso")
+	if err := d.DownloadToFile(context.Background(), server.URL, destPath, sha256Hex(content)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %v", err)
+	}
+	if string(got) != content {
+		t.Errorf("got %q, expected %q", got, content)
+	}
+}
+
+func TestDownloadToFileRejectsChecksumMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("unexpected-contents"))
+	}))
+	defer server.Close()
+
+	dataDir := t.TempDir()
+	d := NewDownloader(dataDir, http.DefaultClient)
+
+	destPath := filepath.Join(dataDir, "rhcos-live.iso")
+	err := d.DownloadToFile(context.Background(), server.URL, destPath, sha256Hex("expected-contents"))
+	if err == nil {
+		t.Fatal("expected a checksum mismatch error")
+	}
+}
+
+func TestDownloadToFileResumesFromPartial(t *testing.T) {
+	const full = "0123456789abcdefghij"
+	const already = "0123456789"
+
+	var gotRange string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		if gotRange == "" {
+			w.Write([]byte(full))
+			return
+		}
+		w.Header().Set("ETag", "\"fixture-etag\"")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(full[len(already):]))
+	}))
+	defer server.Close()
+
+	dataDir := t.TempDir()
+	d := NewDownloader(dataDir, http.DefaultClient)
+
+	partPath := filepath.Join(dataDir, casDirName, partFileName(server.URL, sha256Hex(full)))
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		t.Fatalf("failed to set up part dir: %v", err)
+	}
+	if err := ioutil.WriteFile(partPath, []byte(already), 0644); err != nil {
+		t.Fatalf("failed to seed partial download: %v", err)
+	}
+	if err := ioutil.WriteFile(partPath+etagSuffix, []byte("\"fixture-etag\""), 0644); err != nil {
+		t.Fatalf("failed to seed etag sidecar: %v", err)
+	}
+
+	destPath := filepath.Join(dataDir, "rhcos-live.iso")
+	if err := d.DownloadToFile(context.Background(), server.URL, destPath, sha256Hex(full)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotRange != "bytes=10-" {
+		t.Errorf("expected a resume Range header, got %q", gotRange)
+	}
+
+	got, err := ioutil.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("failed to read downloaded file: %v", err)
+	}
+	if string(got) != full {
+		t.Errorf("got %q, expected %q", got, full)
+	}
+}
@@ -0,0 +1,70 @@
+package imagestore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseMirrorRewrites(t *testing.T) {
+	rewrites, err := parseMirrorRewrites([]string{
+		"https://mirror.openshift.com/=https://internal-mirror.example.com/",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f := &HTTPFetcher{rewrites: rewrites}
+
+	rewritten := f.rewriteURL("https://mirror.openshift.com/pub/openshift-v4/rhcos.iso")
+	expected := "https://internal-mirror.example.com/pub/openshift-v4/rhcos.iso"
+	if rewritten != expected {
+		t.Errorf("got %q, expected %q", rewritten, expected)
+	}
+
+	unmatched := "https://example.com/other.iso"
+	if got := f.rewriteURL(unmatched); got != unmatched {
+		t.Errorf("expected non-matching URL to pass through unchanged, got %q", got)
+	}
+}
+
+func TestParseMirrorRewritesRejectsInvalidRule(t *testing.T) {
+	if _, err := parseMirrorRewrites([]string{"no-equals-sign"}); err == nil {
+		t.Fatal("expected an error for a malformed rewrite rule")
+	}
+}
+
+func TestHTTPFetcherRetriesOn5xx(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	f := &HTTPFetcher{
+		client:      http.DefaultClient,
+		maxRetries:  5,
+		backoffBase: time.Millisecond,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resp, err := f.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/openshift/assisted-image-service/pkg/isoeditor"
@@ -15,37 +15,103 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
-var DefaultVersions = map[string]map[string]string{
+// DefaultArch is used whenever a caller does not specify a CPU architecture,
+// preserving the behavior of versions configured before multi-arch support
+// was added.
+const DefaultArch = "x86_64"
+
+var DefaultVersions = map[string]map[string]map[string]string{
 	"4.6": {
-		"iso_url":    "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/4.6/4.6.8/rhcos-4.6.8-x86_64-live.x86_64.iso",
-		"rootfs_url": "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/4.6/4.6.8/rhcos-live-rootfs.x86_64.img",
+		DefaultArch: {
+			"iso_url":    "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/4.6/4.6.8/rhcos-4.6.8-x86_64-live.x86_64.iso",
+			"rootfs_url": "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/4.6/4.6.8/rhcos-live-rootfs.x86_64.img",
+		},
 	},
 	"4.7": {
-		"iso_url":    "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/4.7/4.7.13/rhcos-4.7.13-x86_64-live.x86_64.iso",
-		"rootfs_url": "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/4.7/4.7.13/rhcos-live-rootfs.x86_64.img",
+		DefaultArch: {
+			"iso_url":    "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/4.7/4.7.13/rhcos-4.7.13-x86_64-live.x86_64.iso",
+			"rootfs_url": "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/4.7/4.7.13/rhcos-live-rootfs.x86_64.img",
+		},
 	},
 	"4.8": {
-		"iso_url":    "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/pre-release/4.8.0-rc.3/rhcos-4.8.0-rc.3-x86_64-live.x86_64.iso",
-		"rootfs_url": "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/pre-release/4.8.0-rc.3/rhcos-live-rootfs.x86_64.img",
+		DefaultArch: {
+			"iso_url":    "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/pre-release/4.8.0-rc.3/rhcos-4.8.0-rc.3-x86_64-live.x86_64.iso",
+			"rootfs_url": "https://mirror.openshift.com/pub/openshift-v4/dependencies/rhcos/pre-release/4.8.0-rc.3/rhcos-live-rootfs.x86_64.img",
+		},
 	},
 }
 
 //go:generate mockgen -package=imagestore -destination=mock_imagestore.go . ImageStore
 type ImageStore interface {
 	Populate(ctx context.Context) error
-	BaseFile(version, imageType string) (string, error)
-	HaveVersion(version string) bool
+	BaseFile(version, arch, imageType string) (string, error)
+	HaveVersion(version, arch string) bool
+
+	// Run refreshes the version configuration and garbage-collects stale
+	// artifacts on an interval until ctx is canceled. It is intended to be
+	// started once, alongside Populate, by main.
+	Run(ctx context.Context) error
+
+	// Pin marks path as in use by a caller (e.g. an HTTP handler actively
+	// streaming it) so that background GC will not remove it until the
+	// returned release function is called.
+	Pin(path string) func()
 }
 
 type Config struct {
 	Versions string `envconfig:"RHCOS_VERSIONS"`
+
+	// ReleaseImages, when set, is a JSON-encoded []ReleaseImage. It allows
+	// RHCOS artifacts to be derived from a release image's
+	// machine-os-content instead of (or alongside) Versions.
+	ReleaseImages  string `envconfig:"RELEASE_IMAGES"`
+	PullSecretPath string `envconfig:"PULL_SECRET_PATH"`
+
+	// VersionsFile, when set, is watched by Run and re-read on every
+	// refresh tick (and on SIGHUP) in place of Versions, so the version
+	// map can be edited without restarting the service.
+	VersionsFile string `envconfig:"RHCOS_VERSIONS_FILE"`
+
+	RefreshInterval time.Duration `envconfig:"REFRESH_INTERVAL" default:"10m"`
+	GCGracePeriod   time.Duration `envconfig:"GC_GRACE_PERIOD" default:"1h"`
+
+	Fetcher FetcherConfig
 }
 
+// versionMap is keyed first by OpenShift version, then by CPU architecture,
+// and finally holds the "iso_url"/"rootfs_url" pair for that (version, arch).
+type versionMap map[string]map[string]map[string]string
+
 type rhcosStore struct {
-	cfg       *Config
-	versions  map[string]map[string]string
-	isoEditor isoeditor.Editor
-	dataDir   string
+	cfg        *Config
+	resolvers  []VersionResolver
+	downloader *Downloader
+	isoEditor  isoeditor.Editor
+	dataDir    string
+
+	// versionsMu guards versions: Run's background refresh writes it from
+	// one goroutine while HTTP handlers read it (via BaseFile/HaveVersion)
+	// from others.
+	versionsMu sync.RWMutex
+	versions   versionMap
+
+	refMu     sync.Mutex
+	refCounts map[string]int
+}
+
+// getVersions returns the current version map. The returned map (and its
+// nested maps) must be treated as read-only: updates always replace it
+// wholesale via setVersions rather than mutating it in place.
+func (s *rhcosStore) getVersions() versionMap {
+	s.versionsMu.RLock()
+	defer s.versionsMu.RUnlock()
+	return s.versions
+}
+
+func (s *rhcosStore) setVersions(v versionMap) {
+	s.versionsMu.Lock()
+	defer s.versionsMu.Unlock()
+	s.versions = v
 }
 
 const (
@@ -59,134 +125,210 @@ func NewImageStore(ed isoeditor.Editor, dataDir string) (ImageStore, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	fetcher, err := NewHTTPFetcher(cfg.Fetcher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP fetcher: %w", err)
+	}
+
 	is := rhcosStore{
-		cfg:       cfg,
-		isoEditor: ed,
-		dataDir:   dataDir,
+		cfg:        cfg,
+		versions:   versionMap{},
+		downloader: NewDownloader(dataDir, fetcher),
+		isoEditor:  ed,
+		dataDir:    dataDir,
+		refCounts:  map[string]int{},
 	}
-	if cfg.Versions == "" {
-		is.versions = DefaultVersions
-	} else {
+	switch {
+	case cfg.VersionsFile != "":
+		if err = is.loadVersionsFile(); err != nil {
+			return nil, err
+		}
+	case cfg.Versions != "":
 		err = json.Unmarshal([]byte(cfg.Versions), &is.versions)
 		if err != nil {
 			return nil, err
 		}
+	case cfg.ReleaseImages == "":
+		is.versions = DefaultVersions
 	}
-	return &is, nil
-}
 
-func downloadURLToFile(url string, path string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
+	// Statically/VersionsFile-configured entries are not registered as a
+	// VersionResolver: resolveVersions already seeds its working copy from
+	// the live s.versions on every call (including after loadVersionsFile
+	// replaces it on refresh), so a resolver snapshot here would just go
+	// stale and clobber live edits back to their startup values.
+	if cfg.ReleaseImages != "" {
+		var images []ReleaseImage
+		if err = json.Unmarshal([]byte(cfg.ReleaseImages), &images); err != nil {
+			return nil, fmt.Errorf("failed to parse RELEASE_IMAGES: %w", err)
+		}
+		is.resolvers = append(is.resolvers, newReleaseImageResolver(images, cfg.PullSecretPath))
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("Request to %s returned error code %d", url, resp.StatusCode)
-	}
+	return &is, nil
+}
 
-	f, err := os.Create(path)
-	if err != nil {
-		return err
+// resolveVersions asks every configured VersionResolver for the (version,
+// arch) pairs it knows about, resolves the iso_url/rootfs_url metadata for
+// each into a new version map, and swaps it in with setVersions. Resolution
+// (which may shell out or hit the network) happens entirely on a local copy
+// so readers never observe a partially rebuilt map.
+func (s *rhcosStore) resolveVersions(ctx context.Context) error {
+	merged := versionMap{}
+	for version, archs := range s.getVersions() {
+		archsCopy := make(map[string]map[string]string, len(archs))
+		for arch, urls := range archs {
+			archsCopy[arch] = urls
+		}
+		merged[version] = archsCopy
 	}
-	defer f.Close()
 
-	count, err := io.Copy(f, resp.Body)
-	if err != nil {
-		return err
-	} else if count != resp.ContentLength {
-		return fmt.Errorf("Wrote %d bytes, but expected to write %d", count, resp.ContentLength)
+	for _, resolver := range s.resolvers {
+		for version, archs := range resolver.Versions() {
+			for _, arch := range archs {
+				urls, err := resolver.Resolve(ctx, version, arch)
+				if err != nil {
+					return fmt.Errorf("failed to resolve %s (%s): %w", version, arch, err)
+				}
+				if merged[version] == nil {
+					merged[version] = map[string]map[string]string{}
+				}
+				merged[version][arch] = urls
+			}
+		}
 	}
 
+	s.setVersions(merged)
 	return nil
 }
 
+// versionEntry returns the (version, arch) entry, defaulting arch to
+// DefaultArch when the caller leaves it blank.
+func (s *rhcosStore) versionEntry(version, arch string) (map[string]string, error) {
+	if arch == "" {
+		arch = DefaultArch
+	}
+	archs, ok := s.getVersions()[version]
+	if !ok {
+		return nil, fmt.Errorf("missing version entry for %s", version)
+	}
+	v, ok := archs[arch]
+	if !ok {
+		return nil, fmt.Errorf("missing arch %s entry for version %s", arch, version)
+	}
+	return v, nil
+}
+
 func (s *rhcosStore) Populate(ctx context.Context) error {
+	if err := s.resolveVersions(ctx); err != nil {
+		return err
+	}
+
 	errs, _ := errgroup.WithContext(ctx)
 
-	for version := range s.versions {
+	for version, archs := range s.getVersions() {
 		version := version
-		errs.Go(func() error {
-			fullPath, err := s.pathForVersion(version)
-			if err != nil {
-				return err
-			}
-
-			if _, err = os.Stat(fullPath); os.IsNotExist(err) {
-				url := s.versions[version]["iso_url"]
-				log.Infof("Downloading iso from %s to %s", url, fullPath)
-				err = downloadURLToFile(url, fullPath)
+		for arch, urls := range archs {
+			arch, urls := arch, urls
+			errs.Go(func() error {
+				fullPath, err := s.pathForVersion(version, arch)
 				if err != nil {
-					return fmt.Errorf("failed to download %s: %v", url, err)
+					return err
 				}
-				log.Infof("Finished downloading for version %s", version)
-			}
 
-			minimalPath, err := s.minimalPathForVersion(version)
-			if err != nil {
-				return err
-			}
+				if _, err = os.Stat(fullPath); os.IsNotExist(err) {
+					url := urls["iso_url"]
+					sha256sum := urls["iso_sha256"]
+					log.Infof("Downloading iso from %s to %s", url, fullPath)
 
-			if _, err = os.Stat(minimalPath); os.IsNotExist(err) {
-				log.Infof("Creating minimal iso for version %s", version)
+					start := time.Now()
+					err = s.downloader.DownloadToFile(ctx, url, fullPath, sha256sum)
+					downloadDurationSeconds.WithLabelValues(version, arch).Observe(time.Since(start).Seconds())
+					if err != nil {
+						versionReady.WithLabelValues(version, arch).Set(0)
+						return fmt.Errorf("failed to download %s: %v", url, err)
+					}
+					if fi, statErr := os.Stat(fullPath); statErr == nil {
+						downloadBytesTotal.WithLabelValues(version, arch).Add(float64(fi.Size()))
+					}
+					log.Infof("Finished downloading for version %s (%s)", version, arch)
+				}
 
-				rootfsURL, err := s.rootfsURLForVersion(version)
+				minimalPath, err := s.minimalPathForVersion(version, arch)
 				if err != nil {
 					return err
 				}
 
-				err = s.isoEditor.CreateMinimalISOTemplate(fullPath, rootfsURL, minimalPath)
-				if err != nil {
-					return fmt.Errorf("failed to create minimal iso template for version %s: %v", version, err)
+				if _, err = os.Stat(minimalPath); os.IsNotExist(err) {
+					log.Infof("Creating minimal iso for version %s (%s)", version, arch)
+
+					rootfsURL, err := s.rootfsURLForVersion(version, arch)
+					if err != nil {
+						return err
+					}
+
+					if rootfsSHA256 := urls["rootfs_sha256"]; rootfsSHA256 != "" {
+						if err := s.downloader.VerifyChecksum(ctx, rootfsURL, rootfsSHA256); err != nil {
+							versionReady.WithLabelValues(version, arch).Set(0)
+							return fmt.Errorf("failed to verify rootfs for version %s (%s): %v", version, arch, err)
+						}
+					}
+
+					err = s.isoEditor.CreateMinimalISOTemplate(fullPath, rootfsURL, minimalPath)
+					if err != nil {
+						versionReady.WithLabelValues(version, arch).Set(0)
+						return fmt.Errorf("failed to create minimal iso template for version %s (%s): %v", version, arch, err)
+					}
+					log.Infof("Finished creating minimal iso for version %s (%s)", version, arch)
 				}
-				log.Infof("Finished creating minimal iso for version %s", version)
-			}
 
-			return nil
-		})
+				versionReady.WithLabelValues(version, arch).Set(1)
+				return nil
+			})
+		}
 	}
 
 	return errs.Wait()
 }
 
-func (s *rhcosStore) rootfsURLForVersion(version string) (string, error) {
-	v, ok := s.versions[version]
-	if !ok {
-		return "", fmt.Errorf("missing version entry for %s", version)
+func (s *rhcosStore) rootfsURLForVersion(version, arch string) (string, error) {
+	v, err := s.versionEntry(version, arch)
+	if err != nil {
+		return "", err
 	}
 	url, ok := v["rootfs_url"]
 	if !ok {
-		return "", fmt.Errorf("version %s missing key 'rootfs_url'", version)
+		return "", fmt.Errorf("version %s (%s) missing key 'rootfs_url'", version, arch)
 	}
 	return url, nil
 }
 
-func (s *rhcosStore) pathForVersion(version string) (string, error) {
-	v, ok := s.versions[version]
-	if !ok {
-		return "", fmt.Errorf("missing version entry for %s", version)
+func (s *rhcosStore) pathForVersion(version, arch string) (string, error) {
+	v, err := s.versionEntry(version, arch)
+	if err != nil {
+		return "", err
 	}
 	url, ok := v["iso_url"]
 	if !ok {
-		return "", fmt.Errorf("version %s missing key 'iso_url'", version)
+		return "", fmt.Errorf("version %s (%s) missing key 'iso_url'", version, arch)
 	}
 	return filepath.Join(s.dataDir, filepath.Base(url)), nil
 }
 
-func (s *rhcosStore) minimalPathForVersion(version string) (string, error) {
-	v, ok := s.versions[version]
-	if !ok {
-		return "", fmt.Errorf("missing version entry for %s", version)
+func (s *rhcosStore) minimalPathForVersion(version, arch string) (string, error) {
+	v, err := s.versionEntry(version, arch)
+	if err != nil {
+		return "", err
 	}
 	url, ok := v["iso_url"]
 	if !ok {
-		return "", fmt.Errorf("version %s missing key 'iso_url'", version)
+		return "", fmt.Errorf("version %s (%s) missing key 'iso_url'", version, arch)
 	}
 	return filepath.Join(s.dataDir, "minimal-"+filepath.Base(url)), nil
 }
 
-func (s *rhcosStore) BaseFile(version, imageType string) (string, error) {
+func (s *rhcosStore) BaseFile(version, arch, imageType string) (string, error) {
 	var (
 		path string
 		err  error
@@ -194,9 +336,9 @@ func (s *rhcosStore) BaseFile(version, imageType string) (string, error) {
 
 	switch imageType {
 	case ImageTypeFull:
-		path, err = s.pathForVersion(version)
+		path, err = s.pathForVersion(version, arch)
 	case ImageTypeMinimal:
-		path, err = s.minimalPathForVersion(version)
+		path, err = s.minimalPathForVersion(version, arch)
 	default:
 		err = fmt.Errorf("unsupported image type '%s'", imageType)
 	}
@@ -207,7 +349,7 @@ func (s *rhcosStore) BaseFile(version, imageType string) (string, error) {
 	return path, nil
 }
 
-func (s *rhcosStore) HaveVersion(version string) bool {
-	_, ok := s.versions[version]
-	return ok
+func (s *rhcosStore) HaveVersion(version, arch string) bool {
+	_, err := s.versionEntry(version, arch)
+	return err == nil
 }
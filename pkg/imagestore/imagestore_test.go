@@ -0,0 +1,71 @@
+package imagestore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestStore(versions versionMap, dataDir string) *rhcosStore {
+	return &rhcosStore{
+		cfg:       &Config{},
+		versions:  versions,
+		dataDir:   dataDir,
+		refCounts: map[string]int{},
+	}
+}
+
+func TestVersionEntryDefaultsToX86_64(t *testing.T) {
+	versions := versionMap{
+		"4.9": {
+			DefaultArch: {"iso_url": "https://example.com/x86_64.iso"},
+			"arm64":     {"iso_url": "https://example.com/arm64.iso"},
+		},
+	}
+	s := newTestStore(versions, t.TempDir())
+
+	v, err := s.versionEntry("4.9", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v["iso_url"] != "https://example.com/x86_64.iso" {
+		t.Errorf("expected blank arch to default to %s, got %v", DefaultArch, v)
+	}
+
+	v, err = s.versionEntry("4.9", "arm64")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v["iso_url"] != "https://example.com/arm64.iso" {
+		t.Errorf("unexpected arm64 entry: %v", v)
+	}
+
+	if _, err := s.versionEntry("4.9", "s390x"); err == nil {
+		t.Fatal("expected an error for an unconfigured arch")
+	}
+}
+
+func TestHaveVersionAndBaseFile(t *testing.T) {
+	versions := versionMap{
+		"4.9": {
+			DefaultArch: {"iso_url": "https://example.com/rhcos-4.9-x86_64-live.iso"},
+		},
+	}
+	dataDir := t.TempDir()
+	s := newTestStore(versions, dataDir)
+
+	if !s.HaveVersion("4.9", "") {
+		t.Error("expected HaveVersion to be true for a configured version")
+	}
+	if s.HaveVersion("4.10", "") {
+		t.Error("expected HaveVersion to be false for an unconfigured version")
+	}
+
+	path, err := s.BaseFile("4.9", "", ImageTypeFull)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := filepath.Join(dataDir, "rhcos-4.9-x86_64-live.iso")
+	if path != expected {
+		t.Errorf("got %q, expected %q", path, expected)
+	}
+}
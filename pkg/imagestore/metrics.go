@@ -0,0 +1,39 @@
+package imagestore
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics for the background Run loop: how much data is moving through
+// Populate/refresh, how long it takes, what GC is removing, and whether
+// each configured (version, arch) pair is actually ready to serve, so
+// operators can observe a rollout when the version map is edited at
+// runtime instead of only finding out from a 404.
+var (
+	downloadBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "assisted_image_service",
+		Name:      "download_bytes_total",
+		Help:      "Total bytes downloaded for RHCOS artifacts.",
+	}, []string{"version", "arch"})
+
+	downloadDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "assisted_image_service",
+		Name:      "download_duration_seconds",
+		Help:      "Duration of RHCOS artifact downloads.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"version", "arch"})
+
+	gcDeletionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "assisted_image_service",
+		Name:      "gc_deletions_total",
+		Help:      "Total number of stale files removed by background garbage collection.",
+	})
+
+	versionReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "assisted_image_service",
+		Name:      "version_ready",
+		Help:      "Whether a (version, arch) pair is fully downloaded and ready to serve (1) or not (0).",
+	}, []string{"version", "arch"})
+)
+
+func init() {
+	prometheus.MustRegister(downloadBytesTotal, downloadDurationSeconds, gcDeletionsTotal, versionReady)
+}
@@ -0,0 +1,202 @@
+package imagestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// ReleaseImage describes a single OpenShift release payload pullspec that
+// the releaseImage VersionResolver can derive RHCOS artifacts from. This
+// mirrors the shape assisted-service itself uses to describe release images.
+type ReleaseImage struct {
+	OpenshiftVersion string `json:"openshift_version"`
+	CPUArchitecture  string `json:"cpu_architecture"`
+	URL              string `json:"url"`
+}
+
+// VersionResolver produces the "iso_url"/"rootfs_url" metadata for a given
+// (version, arch) pair and enumerates the (version, arch) pairs it knows
+// about. ImageStore uses it to populate its version map before Populate
+// downloads anything, so that either statically configured URLs or URLs
+// derived from a release image can be treated identically afterwards.
+type VersionResolver interface {
+	Resolve(ctx context.Context, version, arch string) (map[string]string, error)
+	Versions() map[string][]string
+}
+
+// releaseImageResolver derives RHCOS iso_url/rootfs_url values from an
+// OpenShift release image's machine-os-content, instead of requiring them
+// to be configured directly. This removes the drift between the image
+// service and the rest of the cluster that comes from hand-maintaining
+// RHCOS_VERSIONS alongside the release payload.
+type releaseImageResolver struct {
+	images         []ReleaseImage
+	pullSecretPath string
+
+	// ocAdmReleaseInfo and ocImageExtract are overridable for testing.
+	ocAdmReleaseInfo func(pullspec, pullSecretPath string) (string, error)
+	ocImageExtract   func(pullspec, pullSecretPath, destDir string) error
+}
+
+func newReleaseImageResolver(images []ReleaseImage, pullSecretPath string) *releaseImageResolver {
+	return &releaseImageResolver{
+		images:           images,
+		pullSecretPath:   pullSecretPath,
+		ocAdmReleaseInfo: ocAdmReleaseImageFor,
+		ocImageExtract:   ocImageExtractPath,
+	}
+}
+
+func (r *releaseImageResolver) Versions() map[string][]string {
+	out := make(map[string][]string, len(r.images))
+	for _, img := range r.images {
+		out[img.OpenshiftVersion] = append(out[img.OpenshiftVersion], img.CPUArchitecture)
+	}
+	return out
+}
+
+func (r *releaseImageResolver) Resolve(ctx context.Context, version, arch string) (map[string]string, error) {
+	var pullspec string
+	for _, img := range r.images {
+		if img.OpenshiftVersion == version && img.CPUArchitecture == arch {
+			pullspec = img.URL
+			break
+		}
+	}
+	if pullspec == "" {
+		return nil, fmt.Errorf("no release image configured for version %s (%s)", version, arch)
+	}
+
+	// machine-os-content carries the RHCOS build metadata (build ID, etc.)
+	// for this release, which we log for traceability; the bootimages
+	// ConfigMap itself lives in the release payload image, not in
+	// machine-os-content, so extraction below reads from pullspec.
+	machineOSContent, err := r.ocAdmReleaseInfo(pullspec, r.pullSecretPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve machine-os-content for %s: %w", pullspec, err)
+	}
+
+	destDir, err := ioutil.TempDir("", "release-manifests")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(destDir)
+
+	if err := r.ocImageExtract(pullspec, r.pullSecretPath, destDir); err != nil {
+		return nil, fmt.Errorf("failed to extract release manifests from %s: %w", pullspec, err)
+	}
+
+	manifestPath := filepath.Join(destDir, "0000_50_installer_coreos-bootimages.yaml")
+	urls, err := parseBootimagesManifest(manifestPath, arch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bootimages manifest for %s (%s): %w", version, arch, err)
+	}
+
+	log.Infof("resolved RHCOS artifacts for %s (%s) from release image %s (machine-os-content %s)", version, arch, pullspec, machineOSContent)
+	return urls, nil
+}
+
+// bootimagesConfigMap is the subset of the 0000_50_installer_coreos-bootimages
+// ConfigMap that carries the per-architecture stream metadata.
+type bootimagesConfigMap struct {
+	Data map[string]string `yaml:"data"`
+}
+
+type streamArch struct {
+	Artifacts struct {
+		Metal struct {
+			Formats struct {
+				ISO struct {
+					Disk struct {
+						Location string `json:"location"`
+					} `json:"disk"`
+				} `json:"iso"`
+				PXE struct {
+					Rootfs struct {
+						Location string `json:"location"`
+					} `json:"rootfs"`
+				} `json:"pxe"`
+			} `json:"formats"`
+		} `json:"metal"`
+	} `json:"artifacts"`
+}
+
+type streamMetadata struct {
+	Architectures map[string]streamArch `json:"architectures"`
+}
+
+func parseBootimagesManifest(manifestPath, arch string) (map[string]string, error) {
+	raw, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var cm bootimagesConfigMap
+	if err := yaml.Unmarshal(raw, &cm); err != nil {
+		return nil, err
+	}
+
+	streamJSON, ok := cm.Data["stream"]
+	if !ok {
+		return nil, fmt.Errorf("bootimages configmap missing 'stream' key")
+	}
+
+	var stream streamMetadata
+	if err := json.Unmarshal([]byte(streamJSON), &stream); err != nil {
+		return nil, err
+	}
+
+	a, ok := stream.Architectures[arch]
+	if !ok {
+		return nil, fmt.Errorf("no bootimages entry for arch %s", arch)
+	}
+
+	return map[string]string{
+		"iso_url":    a.Artifacts.Metal.Formats.ISO.Disk.Location,
+		"rootfs_url": a.Artifacts.Metal.Formats.PXE.Rootfs.Location,
+	}, nil
+}
+
+// ocAdmReleaseImageFor shells out to `oc adm release info --image-for
+// machine-os-content` to resolve the digest-pinned machine-os-content
+// pullspec for a release image.
+func ocAdmReleaseImageFor(pullspec, pullSecretPath string) (string, error) {
+	args := []string{"adm", "release", "info", "--image-for=machine-os-content", pullspec}
+	if pullSecretPath != "" {
+		args = append(args, "--registry-config="+pullSecretPath)
+	}
+	out, err := exec.Command("oc", args...).Output()
+	if err != nil {
+		return "", err
+	}
+	return trimNewline(string(out)), nil
+}
+
+// ocImageExtractPath shells out to `oc image extract` to pull the
+// release-manifests directory out of the release payload image.
+func ocImageExtractPath(pullspec, pullSecretPath, destDir string) error {
+	args := []string{"image", "extract", pullspec, "--path=/release-manifests/:" + destDir, "--confirm"}
+	if pullSecretPath != "" {
+		args = append(args, "--registry-config="+pullSecretPath)
+	}
+	cmd := exec.Command("oc", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, string(out))
+	}
+	return nil
+}
+
+func trimNewline(s string) string {
+	for len(s) > 0 && (s[len(s)-1] == '\n' || s[len(s)-1] == '\r') {
+		s = s[:len(s)-1]
+	}
+	return s
+}
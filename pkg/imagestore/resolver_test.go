@@ -0,0 +1,115 @@
+package imagestore
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const bootimagesFixture = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: coreos-bootimages
+data:
+  stream: |-
+    {
+      "architectures": {
+        "x86_64": {
+          "artifacts": {
+            "metal": {
+              "formats": {
+                "iso": {
+                  "disk": {
+                    "location": "https://example.com/rhcos-live.x86_64.iso"
+                  }
+                },
+                "pxe": {
+                  "rootfs": {
+                    "location": "https://example.com/rhcos-live-rootfs.x86_64.img"
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+`
+
+func TestParseBootimagesManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "0000_50_installer_coreos-bootimages.yaml")
+	if err := ioutil.WriteFile(manifestPath, []byte(bootimagesFixture), 0644); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+
+	urls, err := parseBootimagesManifest(manifestPath, "x86_64")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if urls["iso_url"] != "https://example.com/rhcos-live.x86_64.iso" {
+		t.Errorf("unexpected iso_url: %s", urls["iso_url"])
+	}
+	if urls["rootfs_url"] != "https://example.com/rhcos-live-rootfs.x86_64.img" {
+		t.Errorf("unexpected rootfs_url: %s", urls["rootfs_url"])
+	}
+}
+
+func TestParseBootimagesManifestMissingArch(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "0000_50_installer_coreos-bootimages.yaml")
+	if err := ioutil.WriteFile(manifestPath, []byte(bootimagesFixture), 0644); err != nil {
+		t.Fatalf("failed to write fixture: %v", err)
+	}
+
+	if _, err := parseBootimagesManifest(manifestPath, "arm64"); err == nil {
+		t.Fatal("expected an error for an unconfigured arch")
+	}
+}
+
+// TestResolveExtractsFromReleasePullspec guards against regressing back to
+// extracting the bootimages manifest from machine-os-content: that image
+// has no release-manifests directory, so oc image extract must run against
+// the release payload pullspec instead.
+func TestResolveExtractsFromReleasePullspec(t *testing.T) {
+	const pullspec = "quay.io/openshift-release-dev/ocp-release:4.9.0-x86_64"
+	const machineOSContent = "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:deadbeef"
+
+	r := &releaseImageResolver{
+		images: []ReleaseImage{
+			{OpenshiftVersion: "4.9", CPUArchitecture: "x86_64", URL: pullspec},
+		},
+		pullSecretPath: "/pull-secret.json",
+	}
+
+	var gotReleaseInfoPullspec string
+	r.ocAdmReleaseInfo = func(p, pullSecretPath string) (string, error) {
+		gotReleaseInfoPullspec = p
+		return machineOSContent, nil
+	}
+
+	var gotExtractPullspec string
+	r.ocImageExtract = func(p, pullSecretPath, destDir string) error {
+		gotExtractPullspec = p
+		manifestPath := filepath.Join(destDir, "0000_50_installer_coreos-bootimages.yaml")
+		return ioutil.WriteFile(manifestPath, []byte(bootimagesFixture), 0644)
+	}
+
+	urls, err := r.Resolve(context.Background(), "4.9", "x86_64")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotReleaseInfoPullspec != pullspec {
+		t.Errorf("expected oc adm release info to be called with the release pullspec %q, got %q", pullspec, gotReleaseInfoPullspec)
+	}
+	if gotExtractPullspec != pullspec {
+		t.Errorf("expected oc image extract to be called with the release pullspec %q, got %q (machine-os-content has no release-manifests directory)", pullspec, gotExtractPullspec)
+	}
+	if urls["iso_url"] != "https://example.com/rhcos-live.x86_64.iso" {
+		t.Errorf("unexpected iso_url: %s", urls["iso_url"])
+	}
+}
@@ -0,0 +1,214 @@
+package imagestore
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// loadVersionsFile re-reads cfg.VersionsFile into s.versions. It is used
+// both at startup and by Run on every refresh tick / SIGHUP so the version
+// map can be edited on disk without restarting the service.
+func (s *rhcosStore) loadVersionsFile() error {
+	raw, err := ioutil.ReadFile(s.cfg.VersionsFile)
+	if err != nil {
+		return err
+	}
+	versions := versionMap{}
+	if err := json.Unmarshal(raw, &versions); err != nil {
+		return err
+	}
+	s.setVersions(versions)
+	return nil
+}
+
+// Run periodically refreshes the version configuration, downloads any
+// newly added (version, arch) pairs in the background, and garbage
+// collects artifacts that no longer correspond to a configured pair. It
+// blocks until ctx is canceled.
+func (s *rhcosStore) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.refreshInterval())
+	defer ticker.Stop()
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			s.refresh(ctx, "refresh interval elapsed")
+		case <-sighup:
+			s.refresh(ctx, "received SIGHUP")
+		}
+	}
+}
+
+func (s *rhcosStore) refreshInterval() time.Duration {
+	if s.cfg.RefreshInterval > 0 {
+		return s.cfg.RefreshInterval
+	}
+	return 10 * time.Minute
+}
+
+func (s *rhcosStore) gcGracePeriod() time.Duration {
+	if s.cfg.GCGracePeriod > 0 {
+		return s.cfg.GCGracePeriod
+	}
+	return time.Hour
+}
+
+func (s *rhcosStore) refresh(ctx context.Context, reason string) {
+	log.Infof("refreshing RHCOS versions: %s", reason)
+
+	if s.cfg.VersionsFile != "" {
+		if err := s.loadVersionsFile(); err != nil {
+			log.Errorf("failed to reload %s: %v", s.cfg.VersionsFile, err)
+			return
+		}
+	}
+
+	if err := s.Populate(ctx); err != nil {
+		log.Errorf("failed to populate newly configured versions: %v", err)
+	}
+
+	if err := s.collectGarbage(); err != nil {
+		log.Errorf("failed to garbage collect stale versions: %v", err)
+	}
+}
+
+// Pin marks path as in use, preventing collectGarbage from removing it
+// (or the content-addressed blob it points to) until the returned release
+// function runs. HTTP handlers should call this before streaming a file
+// and defer the release.
+func (s *rhcosStore) Pin(path string) func() {
+	s.refMu.Lock()
+	s.refCounts[path]++
+	s.refMu.Unlock()
+
+	var released bool
+	return func() {
+		s.refMu.Lock()
+		defer s.refMu.Unlock()
+		if released {
+			return
+		}
+		released = true
+		s.refCounts[path]--
+		if s.refCounts[path] <= 0 {
+			delete(s.refCounts, path)
+		}
+	}
+}
+
+func (s *rhcosStore) isPinned(path string) bool {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	return s.refCounts[path] > 0
+}
+
+// collectGarbage removes version-named files and content-addressed blobs
+// under dataDir that no longer correspond to any configured (version,
+// arch) pair, skipping anything younger than the GC grace period or
+// currently Pin()ed by a handler.
+func (s *rhcosStore) collectGarbage() error {
+	grace := s.gcGracePeriod()
+	now := time.Now()
+
+	expected := map[string]bool{}
+	for version, archs := range s.getVersions() {
+		for arch := range archs {
+			if p, err := s.pathForVersion(version, arch); err == nil {
+				expected[p] = true
+			}
+			if p, err := s.minimalPathForVersion(version, arch); err == nil {
+				expected[p] = true
+			}
+		}
+	}
+
+	referenced := map[string]bool{}
+	entries, err := ioutil.ReadDir(s.dataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dataDir, entry.Name())
+
+		if expected[path] {
+			if target, err := os.Readlink(path); err == nil {
+				referenced[target] = true
+			}
+			continue
+		}
+
+		if s.isPinned(path) {
+			// No longer configured, but a caller is actively reading it -
+			// keep both the symlink and whatever blob it points at alive
+			// until the pin is released.
+			if target, err := os.Readlink(path); err == nil {
+				referenced[target] = true
+			}
+			continue
+		}
+
+		if now.Sub(entry.ModTime()) < grace {
+			continue
+		}
+
+		s.removeStale(path)
+	}
+
+	casDir := filepath.Join(s.dataDir, casDirName)
+	blobs, err := ioutil.ReadDir(casDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, blob := range blobs {
+		name := blob.Name()
+		if strings.HasSuffix(name, partSuffix) || strings.HasSuffix(name, etagSuffix) {
+			// In-flight download sidecars written by Downloader.fetch, which
+			// doesn't Pin() them itself; a download can run longer than one
+			// grace period, so never sweep these out from under it.
+			continue
+		}
+
+		path := filepath.Join(casDir, name)
+		if referenced[path] || s.isPinned(path) || now.Sub(blob.ModTime()) < grace {
+			continue
+		}
+		s.removeStale(path)
+	}
+
+	return nil
+}
+
+func (s *rhcosStore) removeStale(path string) {
+	log.Infof("garbage collecting stale file %s", path)
+	if err := os.Remove(path); err != nil {
+		log.Warnf("failed to remove %s during gc: %v", path, err)
+		return
+	}
+	gcDeletionsTotal.Inc()
+}
@@ -0,0 +1,103 @@
+package imagestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbageRespectsPinAndGrace(t *testing.T) {
+	dataDir := t.TempDir()
+
+	s := &rhcosStore{
+		cfg:       &Config{GCGracePeriod: time.Hour},
+		versions:  versionMap{},
+		dataDir:   dataDir,
+		refCounts: map[string]int{},
+	}
+
+	stalePath := filepath.Join(dataDir, "stale.iso")
+	pinnedPath := filepath.Join(dataDir, "pinned.iso")
+	freshPath := filepath.Join(dataDir, "fresh.iso")
+
+	for _, p := range []string{stalePath, pinnedPath, freshPath} {
+		if err := ioutil.WriteFile(p, []byte("contents"), 0644); err != nil {
+			t.Fatalf("failed to create fixture %s: %v", p, err)
+		}
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stalePath, old, old); err != nil {
+		t.Fatalf("failed to backdate %s: %v", stalePath, err)
+	}
+	if err := os.Chtimes(pinnedPath, old, old); err != nil {
+		t.Fatalf("failed to backdate %s: %v", pinnedPath, err)
+	}
+
+	release := s.Pin(pinnedPath)
+	defer release()
+
+	if err := s.collectGarbage(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Errorf("expected stale file to be removed, stat err: %v", err)
+	}
+	if _, err := os.Stat(pinnedPath); err != nil {
+		t.Errorf("expected pinned file to survive, got: %v", err)
+	}
+	if _, err := os.Stat(freshPath); err != nil {
+		t.Errorf("expected fresh file to survive, got: %v", err)
+	}
+}
+
+// TestCollectGarbageSkipsInFlightDownloadSidecars guards against a slow
+// download's .part/.etag sidecar files in the CAS directory being swept by
+// collectGarbage while Downloader.fetch is still writing them - they age
+// past the grace period without ever being Pin()ed, since the pin only
+// covers the eventual destination symlink.
+func TestCollectGarbageSkipsInFlightDownloadSidecars(t *testing.T) {
+	dataDir := t.TempDir()
+
+	s := &rhcosStore{
+		cfg:       &Config{GCGracePeriod: time.Hour},
+		versions:  versionMap{},
+		dataDir:   dataDir,
+		refCounts: map[string]int{},
+	}
+
+	casDir := filepath.Join(dataDir, casDirName)
+	if err := os.MkdirAll(casDir, 0755); err != nil {
+		t.Fatalf("failed to create cas dir: %v", err)
+	}
+
+	partPath := filepath.Join(casDir, "abc123"+partSuffix)
+	etagPath := partPath + etagSuffix
+	for _, p := range []string{partPath, etagPath} {
+		if err := ioutil.WriteFile(p, []byte("in-flight"), 0644); err != nil {
+			t.Fatalf("failed to create fixture %s: %v", p, err)
+		}
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(partPath, old, old); err != nil {
+		t.Fatalf("failed to backdate %s: %v", partPath, err)
+	}
+	if err := os.Chtimes(etagPath, old, old); err != nil {
+		t.Fatalf("failed to backdate %s: %v", etagPath, err)
+	}
+
+	if err := s.collectGarbage(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(partPath); err != nil {
+		t.Errorf("expected in-flight .part file to survive gc, got: %v", err)
+	}
+	if _, err := os.Stat(etagPath); err != nil {
+		t.Errorf("expected in-flight .etag file to survive gc, got: %v", err)
+	}
+}